@@ -0,0 +1,132 @@
+package zstack
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// batchWriter coalesces many small Write calls into fewer, larger
+// underlying writes: a background goroutine drains a buffer and flushes it
+// to next as a single concatenated write whenever MaxBatchBytes is reached
+// or on the next tick of a periodic LingerDuration ticker, whichever comes
+// first, so queued bytes wait at most about LingerDuration. Write blocks once the buffer is already at MaxBatchBytes,
+// so a stalled next applies backpressure to callers instead of growing the
+// buffer without bound.
+type batchWriter struct {
+	next    io.Writer
+	opts    PipelineOptions
+	onError func(error)
+
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	buf    []byte
+	closed bool
+
+	flush chan struct{}
+	done  chan struct{}
+}
+
+// newBatchWriter starts a batchWriter draining writes to next. onError, if
+// non-nil, is called whenever a flushed write to next fails; Write itself
+// never returns that error, since by the time it's known the bytes have
+// already been queued and reported as written.
+func newBatchWriter(next io.Writer, opts PipelineOptions, onError func(error)) *batchWriter {
+	if opts.MaxBatchBytes <= 0 {
+		opts.MaxBatchBytes = 1
+	}
+
+	if opts.LingerDuration <= 0 {
+		opts.LingerDuration = time.Microsecond
+	}
+
+	bw := &batchWriter{
+		next:    next,
+		opts:    opts,
+		onError: onError,
+		flush:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	bw.cond = sync.NewCond(&bw.mutex)
+
+	go bw.loop()
+
+	return bw
+}
+
+// Write queues p to be flushed to next, blocking while the buffer is
+// already at MaxBatchBytes rather than growing it further. It returns
+// io.ErrClosedPipe if the batchWriter is closed while waiting for room.
+func (b *batchWriter) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+
+	for len(b.buf) >= b.opts.MaxBatchBytes && !b.closed {
+		b.cond.Wait()
+	}
+
+	if b.closed {
+		b.mutex.Unlock()
+		return 0, io.ErrClosedPipe
+	}
+
+	b.buf = append(b.buf, p...)
+	full := len(b.buf) >= b.opts.MaxBatchBytes
+	b.mutex.Unlock()
+
+	if full {
+		select {
+		case b.flush <- struct{}{}:
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+func (b *batchWriter) loop() {
+	ticker := time.NewTicker(b.opts.LingerDuration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			b.flushNow()
+			return
+		case <-b.flush:
+			b.flushNow()
+		case <-ticker.C:
+			b.flushNow()
+		}
+	}
+}
+
+func (b *batchWriter) flushNow() {
+	b.mutex.Lock()
+	out := b.buf
+	b.buf = nil
+	b.mutex.Unlock()
+	b.cond.Broadcast()
+
+	if len(out) == 0 {
+		return
+	}
+
+	if _, err := b.next.Write(out); err != nil && b.onError != nil {
+		b.onError(err)
+	}
+}
+
+func (b *batchWriter) Close() error {
+	select {
+	case <-b.done:
+	default:
+		close(b.done)
+	}
+
+	b.mutex.Lock()
+	b.closed = true
+	b.mutex.Unlock()
+	b.cond.Broadcast()
+
+	return nil
+}
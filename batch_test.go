@@ -0,0 +1,140 @@
+package zstack
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingWriter blocks every Write until release is closed, simulating a
+// stalled underlying transport (a full TCP send buffer, a wedged serial
+// port).
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{release: make(chan struct{})}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func (b *batchWriter) bufLen() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return len(b.buf)
+}
+
+func TestBatchWriterBackpressure(t *testing.T) {
+	t.Run("Write blocks once the buffer refills to MaxBatchBytes while a flush is stuck", func(t *testing.T) {
+		w := newBlockingWriter()
+		bw := newBatchWriter(w, PipelineOptions{MaxBatchBytes: 4, LingerDuration: time.Millisecond}, nil)
+		defer bw.Close()
+
+		// Triggers a flush that grabs these 4 bytes and blocks inside
+		// w.Write, since release hasn't been closed yet.
+		_, err := bw.Write([]byte{1, 2, 3, 4})
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			return bw.bufLen() == 0
+		}, time.Second, time.Millisecond, "the stuck flush should have already drained the buffer")
+
+		// Refills the buffer to MaxBatchBytes while the flush above is still
+		// stuck, so the next Write has nowhere to go.
+		_, err = bw.Write([]byte{5, 6, 7, 8})
+		assert.NoError(t, err)
+
+		blocked := make(chan struct{})
+
+		go func() {
+			_, _ = bw.Write([]byte{9})
+			close(blocked)
+		}()
+
+		select {
+		case <-blocked:
+			t.Fatal("Write should have blocked with the buffer already at MaxBatchBytes")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(w.release)
+
+		select {
+		case <-blocked:
+		case <-time.After(time.Second):
+			t.Fatal("Write never unblocked after the stalled flush drained the buffer")
+		}
+	})
+
+	t.Run("Close unblocks a pending Write with an error instead of leaving it stuck", func(t *testing.T) {
+		w := newBlockingWriter()
+		bw := newBatchWriter(w, PipelineOptions{MaxBatchBytes: 4, LingerDuration: time.Millisecond}, nil)
+
+		_, err := bw.Write([]byte{1, 2, 3, 4})
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			return bw.bufLen() == 0
+		}, time.Second, time.Millisecond, "the stuck flush should have already drained the buffer")
+
+		_, err = bw.Write([]byte{5, 6, 7, 8})
+		assert.NoError(t, err)
+
+		result := make(chan error, 1)
+
+		go func() {
+			_, err := bw.Write([]byte{9})
+			result <- err
+		}()
+
+		select {
+		case <-result:
+			t.Fatal("Write should have blocked with the buffer already at MaxBatchBytes")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		bw.Close()
+		close(w.release)
+
+		select {
+		case err := <-result:
+			assert.ErrorIs(t, err, io.ErrClosedPipe)
+		case <-time.After(time.Second):
+			t.Fatal("Write never unblocked after Close")
+		}
+	})
+
+	t.Run("a failed flush reaches onError instead of being silently dropped", func(t *testing.T) {
+		boom := assert.AnError
+
+		errs := make(chan error, 1)
+
+		bw := newBatchWriter(errWriter(func(p []byte) (int, error) {
+			return 0, boom
+		}), PipelineOptions{MaxBatchBytes: 1, LingerDuration: time.Millisecond}, func(err error) {
+			errs <- err
+		})
+		defer bw.Close()
+
+		_, err := bw.Write([]byte{1})
+		assert.NoError(t, err)
+
+		select {
+		case err := <-errs:
+			assert.ErrorIs(t, err, boom)
+		case <-time.After(time.Second):
+			t.Fatal("onError was never called for a failed flush")
+		}
+	})
+}
+
+type errWriter func(p []byte) (int, error)
+
+func (f errWriter) Write(p []byte) (int, error) { return f(p) }
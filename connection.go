@@ -0,0 +1,202 @@
+package zstack
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/shimmeringbee/unpi/broker"
+)
+
+// ConnectionState describes a transition in the Transport's connectivity.
+type ConnectionState int
+
+const (
+	ConnectionStateUp ConnectionState = iota
+	ConnectionStateDown
+)
+
+// ConnectionStateEvent is sent on the event channel whenever the underlying
+// Transport connects or disconnects.
+type ConnectionStateEvent struct {
+	State     ConnectionState
+	Transport string
+}
+
+type connection struct {
+	mutex        sync.Mutex
+	transport    Transport
+	stream       io.ReadWriteCloser
+	writer       *batchWriter
+	pipelineOpts PipelineOptions
+
+	// responder is the current broker, wrapped for pipelining. It is
+	// replaced on every dial/reconnect. The stable, public-facing
+	// z.RequestResponder is a reconnectingResponder that always forwards to
+	// whatever responder is current here.
+	responder RequestResponder
+
+	// generation increments on every successful dial, so a write failure
+	// reported by a since-replaced batchWriter doesn't tear down a newer
+	// connection than the one it belonged to, and so a reconnect call that
+	// loses the race below knows to reuse the winner's connection instead
+	// of redialing again.
+	generation uint64
+
+	// reconnectMu is held across an entire reconnect, including the dial,
+	// so two requests that observe a transport error concurrently can't
+	// both tear down and redial at once - the loser blocks here and then,
+	// seeing its observed generation already superseded, reuses the
+	// winner's connection instead of leaking a second socket and batchWriter.
+	reconnectMu sync.Mutex
+}
+
+func (z *ZStack) dial(ctx context.Context) error {
+	rwc, err := z.conn.transport.Dial(ctx)
+	if err != nil {
+		return err
+	}
+
+	z.conn.mutex.Lock()
+	opts := z.conn.pipelineOpts
+	if opts == (PipelineOptions{}) {
+		opts = defaultPipelineOptions
+	}
+
+	z.conn.generation++
+	generation := z.conn.generation
+
+	bw := newBatchWriter(rwc, opts, func(err error) {
+		z.onWriteError(generation, err)
+	})
+
+	z.conn.stream = rwc
+	z.conn.writer = bw
+	z.conn.responder = &pipelinedResponder{
+		next: broker.NewBroker(rwc, bw, z.library),
+		p:    newPipeline(opts),
+	}
+	z.conn.mutex.Unlock()
+
+	z.sendEvent(ConnectionStateEvent{State: ConnectionStateUp, Transport: z.conn.transport.String()})
+
+	return nil
+}
+
+// onWriteError is called by the batchWriter when a coalesced write to the
+// transport fails. The broker never sees that failure directly, since Write
+// already returned successfully by the time the batch is actually flushed,
+// so without this the caller would just time out waiting for an SRSP that
+// was never put on the wire. generation guards against a write failure on
+// an already-replaced connection triggering a needless second reconnect.
+func (z *ZStack) onWriteError(generation uint64, err error) {
+	z.conn.mutex.Lock()
+	current := z.conn.generation
+	z.conn.mutex.Unlock()
+
+	if generation != current {
+		return
+	}
+
+	z.sendEvent(RequestFailedEvent{Err: &ZStackError{Kind: ErrorKindTransport, Retryable: true, Err: err}})
+
+	_ = z.reconnect(context.Background(), generation)
+}
+
+// reconnect tears down the current broker and stream, then redials via the
+// Transport. It is called whenever a request fails with a transport error.
+// observedGeneration is the connection generation the caller saw fail.
+// reconnectMu is held for the whole call, so concurrent callers racing on
+// the same failed connection don't both redial: the loser blocks until the
+// winner finishes, then finds observedGeneration no longer current and
+// returns without touching the connection again, reusing what the winner
+// just dialed.
+func (z *ZStack) reconnect(ctx context.Context, observedGeneration uint64) error {
+	z.conn.reconnectMu.Lock()
+	defer z.conn.reconnectMu.Unlock()
+
+	z.conn.mutex.Lock()
+	current := z.conn.generation
+	z.conn.mutex.Unlock()
+
+	if observedGeneration != current {
+		return nil
+	}
+
+	z.conn.mutex.Lock()
+	stream := z.conn.stream
+	writer := z.conn.writer
+	z.conn.stream = nil
+	z.conn.writer = nil
+	z.conn.mutex.Unlock()
+
+	if writer != nil {
+		_ = writer.Close()
+	}
+
+	if stream != nil {
+		_ = stream.Close()
+	}
+
+	z.sendEvent(ConnectionStateEvent{State: ConnectionStateDown, Transport: z.conn.transport.String()})
+
+	return z.dial(ctx)
+}
+
+// Stop closes the underlying transport connection.
+func (z *ZStack) Stop() error {
+	z.conn.mutex.Lock()
+	defer z.conn.mutex.Unlock()
+
+	if z.conn.writer != nil {
+		_ = z.conn.writer.Close()
+		z.conn.writer = nil
+	}
+
+	if z.conn.stream == nil {
+		return nil
+	}
+
+	err := z.conn.stream.Close()
+	z.conn.stream = nil
+
+	return err
+}
+
+// reconnectingResponder is the stable value assigned to ZStack.RequestResponder.
+// Unlike conn.responder, it is never replaced, so callers who capture
+// z.RequestResponder once keep going through reconnection and retry across any number of dials.
+type reconnectingResponder struct {
+	z *ZStack
+}
+
+func (r *reconnectingResponder) MessageRequestResponse(ctx context.Context, req interface{}, resp interface{}) error {
+	return r.z.requestWithReconnect(ctx, req, resp)
+}
+
+// currentResponder returns the responder wrapping whatever broker is live
+// right now, i.e. the target of the next actual wire request, along with
+// the generation it belongs to - so a caller that goes on to see a
+// transport error from it can tell reconnect which connection it observed
+// fail.
+func (z *ZStack) currentResponder() (RequestResponder, uint64) {
+	z.conn.mutex.Lock()
+	defer z.conn.mutex.Unlock()
+
+	return z.conn.responder, z.conn.generation
+}
+
+func isTransportError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrClosedPipe) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
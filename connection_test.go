@@ -0,0 +1,280 @@
+package zstack
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTransport is a Transport whose Dial behaviour is scripted by the test:
+// dialErrs[i], if non-nil, is returned on the i'th Dial instead of a stream.
+type fakeTransport struct {
+	mutex    sync.Mutex
+	dialErrs []error
+	dials    int
+	streams  []*fakeStream
+}
+
+func (t *fakeTransport) Dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	i := t.dials
+	t.dials++
+
+	if i < len(t.dialErrs) && t.dialErrs[i] != nil {
+		return nil, t.dialErrs[i]
+	}
+
+	s := newFakeStream()
+	t.streams = append(t.streams, s)
+
+	return s, nil
+}
+
+func (t *fakeTransport) String() string {
+	return "fake"
+}
+
+func (t *fakeTransport) dialCount() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.dials
+}
+
+// fakeStream is an io.ReadWriteCloser that blocks reads until closed, in the
+// style of EmptyReader in znp_test.go.
+type fakeStream struct {
+	end      chan struct{}
+	closeOne sync.Once
+}
+
+func newFakeStream() *fakeStream {
+	return &fakeStream{end: make(chan struct{})}
+}
+
+func (s *fakeStream) Read(p []byte) (int, error) {
+	<-s.end
+	return 0, io.EOF
+}
+
+func (s *fakeStream) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (s *fakeStream) Close() error {
+	s.closeOne.Do(func() { close(s.end) })
+	return nil
+}
+
+func (s *fakeStream) isClosed() bool {
+	select {
+	case <-s.end:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestNewWithTransport(t *testing.T) {
+	t.Run("propagates the initial dial error instead of leaving a nil RequestResponder", func(t *testing.T) {
+		transport := &fakeTransport{dialErrs: []error{errors.New("no route to host")}}
+
+		z, err := NewWithTransport(transport)
+		assert.Error(t, err)
+		assert.Nil(t, z)
+	})
+
+	t.Run("on success the ZStack is immediately ready to serve requests", func(t *testing.T) {
+		transport := &fakeTransport{}
+
+		z, err := NewWithTransport(transport)
+		assert.NoError(t, err)
+		defer z.Stop()
+
+		assert.Equal(t, 1, transport.dialCount())
+
+		responder, _ := z.currentResponder()
+		assert.NotNil(t, responder)
+	})
+}
+
+func TestReconnect(t *testing.T) {
+	t.Run("tears down the old stream, redials, and emits connection state events", func(t *testing.T) {
+		transport := &fakeTransport{}
+
+		z, err := NewWithTransport(transport)
+		assert.NoError(t, err)
+		defer z.Stop()
+
+		events, cancel := z.Subscribe(context.Background(), SubscribeOptions{BufferSize: 10})
+		defer cancel()
+
+		firstStream := transport.streams[0]
+
+		_, generation := z.currentResponder()
+		assert.NoError(t, z.reconnect(context.Background(), generation))
+
+		assert.Equal(t, 2, transport.dialCount())
+		assert.True(t, firstStream.isClosed())
+
+		assert.Equal(t, ConnectionStateEvent{State: ConnectionStateDown, Transport: "fake"}, <-events)
+		assert.Equal(t, ConnectionStateEvent{State: ConnectionStateUp, Transport: "fake"}, <-events)
+	})
+
+	t.Run("two concurrent reconnects for the same observed generation only redial once", func(t *testing.T) {
+		transport := &fakeTransport{}
+
+		z, err := NewWithTransport(transport)
+		assert.NoError(t, err)
+		defer z.Stop()
+
+		_, generation := z.currentResponder()
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, z.reconnect(context.Background(), generation))
+			}()
+		}
+
+		wg.Wait()
+
+		assert.Equal(t, 2, transport.dialCount(), "only the first of two racing reconnects should have redialed")
+	})
+}
+
+// fakeResponder is a RequestResponder whose errors are scripted per call, so
+// tests can drive requestWithReconnect's retry decisions directly.
+type fakeResponder struct {
+	mutex  sync.Mutex
+	errs   []error
+	status uint8
+	calls  int
+}
+
+func (f *fakeResponder) MessageRequestResponse(ctx context.Context, req interface{}, resp interface{}) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	i := f.calls
+	f.calls++
+
+	if i < len(f.errs) {
+		return f.errs[i]
+	}
+
+	if sr, ok := resp.(*statusReply); ok {
+		sr.Status = f.status
+	}
+
+	return nil
+}
+
+func (f *fakeResponder) callCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.calls
+}
+
+func TestRequestWithReconnect(t *testing.T) {
+	t.Run("a transport error triggers exactly one reconnect dial before retrying", func(t *testing.T) {
+		transport := &fakeTransport{}
+
+		z, err := NewWithTransport(transport)
+		assert.NoError(t, err)
+		defer z.Stop()
+
+		z.conn.mutex.Lock()
+		z.conn.responder = &fakeResponder{errs: []error{io.EOF}}
+		z.conn.mutex.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		done := make(chan struct{})
+
+		go func() {
+			defer close(done)
+			_ = z.RequestResponder.MessageRequestResponse(ctx, struct{}{}, struct{}{})
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Fatal("MessageRequestResponse never returned after a transport error")
+		}
+
+		assert.Equal(t, 2, transport.dialCount(), "a transport error should trigger exactly one reconnect dial")
+	})
+
+	t.Run("a non-transport error is returned immediately without reconnecting", func(t *testing.T) {
+		transport := &fakeTransport{}
+
+		z, err := NewWithTransport(transport)
+		assert.NoError(t, err)
+		defer z.Stop()
+
+		fake := &fakeResponder{errs: []error{errors.New("bad parameter")}}
+		z.conn.mutex.Lock()
+		z.conn.responder = fake
+		z.conn.mutex.Unlock()
+
+		actualErr := z.RequestResponder.MessageRequestResponse(context.Background(), struct{}{}, struct{}{})
+
+		assert.Error(t, actualErr)
+		assert.Equal(t, 1, fake.callCount(), "should not retry a non-transport error")
+		assert.Equal(t, 1, transport.dialCount(), "should not reconnect for a non-transport error")
+	})
+
+	t.Run("a persistently bad but retryable status exhausts retries without reconnecting, and emits RequestFailedEvent", func(t *testing.T) {
+		transport := &fakeTransport{}
+
+		z, err := NewWithTransport(transport)
+		assert.NoError(t, err)
+		defer z.Stop()
+
+		fake := &fakeResponder{status: uint8(ZMacNoACK)}
+		z.conn.mutex.Lock()
+		z.conn.responder = fake
+		z.conn.mutex.Unlock()
+
+		events, cancel := z.Subscribe(context.Background(), SubscribeOptions{
+			Types:      []interface{}{RequestFailedEvent{}},
+			BufferSize: 1,
+		})
+		defer cancel()
+
+		resp := &statusReply{}
+		actualErr := z.RequestResponder.MessageRequestResponse(context.Background(), struct{}{}, resp)
+
+		assert.Error(t, actualErr)
+
+		var zErr *ZStackError
+		assert.True(t, errors.As(actualErr, &zErr))
+		assert.Equal(t, ErrorKindRetryExhausted, zErr.Kind)
+
+		assert.Equal(t, DefaultZStackRetries, fake.callCount())
+		assert.Equal(t, 1, transport.dialCount(), "a bad status should not trigger a reconnect")
+
+		select {
+		case evt := <-events:
+			failed, ok := evt.(RequestFailedEvent)
+			assert.True(t, ok)
+			assert.Equal(t, ErrorKindRetryExhausted, failed.Err.Kind)
+		case <-time.After(time.Second):
+			t.Fatal("expected a RequestFailedEvent")
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package zstack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ZStatus mirrors the status codes returned by the Z-Stack NPI in the
+// status byte of a synchronous reply, as defined by the TI ZNP spec.
+type ZStatus uint8
+
+const (
+	ZSuccess           ZStatus = 0x00
+	ZFailure           ZStatus = 0x01
+	ZInvalidParameter  ZStatus = 0x02
+	ZMemError          ZStatus = 0x10
+	ZApsIllegalRequest ZStatus = 0xB3
+	ZNwkTableFull      ZStatus = 0xC7
+	ZMacNoACK          ZStatus = 0xE9
+)
+
+func (s ZStatus) String() string {
+	switch s {
+	case ZSuccess:
+		return "ZSuccess"
+	case ZFailure:
+		return "ZFailure"
+	case ZInvalidParameter:
+		return "ZInvalidParameter"
+	case ZMemError:
+		return "ZMemError"
+	case ZApsIllegalRequest:
+		return "ZApsIllegalRequest"
+	case ZNwkTableFull:
+		return "ZNwkTableFull"
+	case ZMacNoACK:
+		return "ZMacNoACK"
+	default:
+		return fmt.Sprintf("ZStatus(0x%02x)", uint8(s))
+	}
+}
+
+// retryableStatuses lists the ZStatus codes considered transient, as
+// opposed to e.g. ZInvalidParameter which will never succeed on retry.
+var retryableStatuses = map[ZStatus]bool{
+	ZMacNoACK:     true,
+	ZNwkTableFull: true,
+	ZMemError:     true,
+}
+
+// ErrorKind distinguishes the broad category of failure behind a
+// ZStackError.
+type ErrorKind int
+
+const (
+	ErrorKindTransport ErrorKind = iota
+	ErrorKindTimeout
+	ErrorKindRetryExhausted
+	ErrorKindStatus
+)
+
+// ZStackError wraps a failed request with enough structure for a caller to
+// decide whether to retry, rather than matching on error strings.
+type ZStackError struct {
+	Kind      ErrorKind
+	Status    ZStatus
+	Retryable bool
+	Err       error
+}
+
+func (e *ZStackError) Error() string {
+	switch e.Kind {
+	case ErrorKindStatus:
+		return fmt.Sprintf("zstack: request failed with status %s", e.Status)
+	case ErrorKindTimeout:
+		return fmt.Sprintf("zstack: request timed out: %s", e.Err)
+	case ErrorKindRetryExhausted:
+		return fmt.Sprintf("zstack: retries exhausted: %s", e.Err)
+	default:
+		return fmt.Sprintf("zstack: transport error: %s", e.Err)
+	}
+}
+
+func (e *ZStackError) Unwrap() error {
+	return e.Err
+}
+
+// StatusReturner is implemented by reply structs that carry a Z-Stack NPI
+// status byte. When present, the responder inspects it and synthesises a
+// ZStackError if it is non-zero, rather than treating the request as having
+// succeeded just because the SRSP arrived.
+type StatusReturner interface {
+	ZStackStatus() uint8
+}
+
+// RequestFailedEvent is sent on the event bus whenever a request ultimately
+// fails, for observability.
+type RequestFailedEvent struct {
+	Request interface{}
+	Err     *ZStackError
+}
+
+// toZStackError classifies the outcome of a MessageRequestResponse call,
+// preferring the NPI status code carried by resp when the transport itself
+// succeeded.
+func toZStackError(ctx context.Context, err error, resp interface{}) *ZStackError {
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return &ZStackError{Kind: ErrorKindTimeout, Retryable: true, Err: err}
+		}
+
+		return &ZStackError{Kind: ErrorKindTransport, Retryable: isTransportError(err), Err: err}
+	}
+
+	if sr, ok := resp.(StatusReturner); ok {
+		if status := ZStatus(sr.ZStackStatus()); status != ZSuccess {
+			return &ZStackError{Kind: ErrorKindStatus, Status: status, Retryable: retryableStatuses[status]}
+		}
+	}
+
+	return nil
+}
+
+// requestWithReconnect performs req against the current inner responder,
+// driving the retry loop off ZStackError.Retryable rather than any non-nil
+// error, and reconnecting via the Transport first when the failure was
+// transport related. It emits RequestFailedEvent once retries are
+// exhausted or the failure is not retryable. This backs ZStack.RequestResponder
+// (a reconnectingResponder) and so is on the path of every real request.
+func (z *ZStack) requestWithReconnect(ctx context.Context, req interface{}, resp interface{}) error {
+	var zErr *ZStackError
+
+	for attempt := 0; attempt < DefaultZStackRetries; attempt++ {
+		responder, generation := z.currentResponder()
+		err := responder.MessageRequestResponse(ctx, req, resp)
+
+		zErr = toZStackError(ctx, err, resp)
+		if zErr == nil {
+			return nil
+		}
+
+		if !zErr.Retryable {
+			z.sendEvent(RequestFailedEvent{Request: req, Err: zErr})
+			return zErr
+		}
+
+		if zErr.Kind == ErrorKindTransport {
+			if err := z.reconnect(ctx, generation); err != nil {
+				wrapped := &ZStackError{Kind: ErrorKindTransport, Err: err}
+				z.sendEvent(RequestFailedEvent{Request: req, Err: wrapped})
+				return wrapped
+			}
+		}
+	}
+
+	exhausted := &ZStackError{Kind: ErrorKindRetryExhausted, Status: zErr.Status, Err: zErr}
+	z.sendEvent(RequestFailedEvent{Request: req, Err: exhausted})
+
+	return exhausted
+}
@@ -0,0 +1,93 @@
+package zstack
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type statusReply struct {
+	Status uint8
+}
+
+func (s statusReply) ZStackStatus() uint8 {
+	return s.Status
+}
+
+func TestToZStackError(t *testing.T) {
+	t.Run("nil error and zero status is not an error", func(t *testing.T) {
+		assert.Nil(t, toZStackError(context.Background(), nil, statusReply{Status: uint8(ZSuccess)}))
+	})
+
+	t.Run("non-zero status is reported and retryable per status", func(t *testing.T) {
+		zErr := toZStackError(context.Background(), nil, statusReply{Status: uint8(ZMacNoACK)})
+
+		assert.Equal(t, ErrorKindStatus, zErr.Kind)
+		assert.Equal(t, ZMacNoACK, zErr.Status)
+		assert.True(t, zErr.Retryable)
+
+		zErr = toZStackError(context.Background(), nil, statusReply{Status: uint8(ZInvalidParameter)})
+
+		assert.Equal(t, ErrorKindStatus, zErr.Kind)
+		assert.False(t, zErr.Retryable)
+	})
+
+	t.Run("a deadline exceeded error is a retryable timeout", func(t *testing.T) {
+		zErr := toZStackError(context.Background(), context.DeadlineExceeded, nil)
+
+		assert.Equal(t, ErrorKindTimeout, zErr.Kind)
+		assert.True(t, zErr.Retryable)
+		assert.True(t, errors.Is(zErr, context.DeadlineExceeded))
+	})
+
+	t.Run("a reply with no status and no error is not an error", func(t *testing.T) {
+		assert.Nil(t, toZStackError(context.Background(), nil, struct{}{}))
+	})
+}
+
+// TestZStackSurfacesStatusErrors proves the classification in toZStackError
+// actually runs for a real ZStack.RequestResponder call, not just in
+// isolation: a bad-but-not-retryable status must come back as a
+// *ZStackError without being retried, and must be reported as a
+// RequestFailedEvent.
+func TestZStackSurfacesStatusErrors(t *testing.T) {
+	t.Run("a non-retryable status short-circuits the retry loop", func(t *testing.T) {
+		transport := &fakeTransport{}
+
+		z, err := NewWithTransport(transport)
+		assert.NoError(t, err)
+		defer z.Stop()
+
+		fake := &fakeResponder{status: uint8(ZInvalidParameter)}
+		z.conn.mutex.Lock()
+		z.conn.responder = fake
+		z.conn.mutex.Unlock()
+
+		events, cancel := z.Subscribe(context.Background(), SubscribeOptions{
+			Types:      []interface{}{RequestFailedEvent{}},
+			BufferSize: 1,
+		})
+		defer cancel()
+
+		resp := &statusReply{}
+		actualErr := z.RequestResponder.MessageRequestResponse(context.Background(), struct{}{}, resp)
+
+		var zErr *ZStackError
+		assert.True(t, errors.As(actualErr, &zErr))
+		assert.Equal(t, ErrorKindStatus, zErr.Kind)
+		assert.Equal(t, ZInvalidParameter, zErr.Status)
+		assert.False(t, zErr.Retryable)
+
+		assert.Equal(t, 1, fake.callCount(), "a non-retryable status must not be retried")
+
+		select {
+		case evt := <-events:
+			failed := evt.(RequestFailedEvent)
+			assert.Equal(t, ZInvalidParameter, failed.Err.Status)
+		default:
+			t.Fatal("expected a RequestFailedEvent to have been emitted")
+		}
+	})
+}
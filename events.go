@@ -3,15 +3,226 @@ package zstack
 import (
 	"context"
 	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
 )
 
+// OverflowPolicy controls what happens to a subscriber's buffer when the
+// ingest goroutine produces events faster than the subscriber drains them.
+type OverflowPolicy int
+
+const (
+	OverflowDropOldest OverflowPolicy = iota
+	OverflowDropNewest
+	OverflowDisconnect
+)
+
+// SubscribeOptions filters and sizes a call to Subscribe. Types, when
+// non-empty, restricts delivery to events whose concrete type matches one of
+// the given zero value instances. Filter, when non-nil, is applied after the
+// type filter.
+type SubscribeOptions struct {
+	Types      []interface{}
+	Filter     func(interface{}) bool
+	BufferSize int
+	Overflow   OverflowPolicy
+}
+
+// SubscriberStats reports delivery health for a single subscription.
+type SubscriberStats struct {
+	BufferSize int
+	Dropped    uint64
+}
+
+type subscription struct {
+	ch       chan interface{}
+	types    map[reflect.Type]bool
+	filter   func(interface{}) bool
+	overflow OverflowPolicy
+	dropped  uint64
+	mutex    sync.Mutex
+	closed   bool
+}
+
+func newSubscription(opts SubscribeOptions) *subscription {
+	bufferSize := opts.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = 1
+	}
+
+	var types map[reflect.Type]bool
+
+	if len(opts.Types) > 0 {
+		types = make(map[reflect.Type]bool, len(opts.Types))
+
+		for _, t := range opts.Types {
+			types[reflect.TypeOf(t)] = true
+		}
+	}
+
+	return &subscription{
+		ch:       make(chan interface{}, bufferSize),
+		types:    types,
+		filter:   opts.Filter,
+		overflow: opts.Overflow,
+	}
+}
+
+func (s *subscription) matches(event interface{}) bool {
+	if s.types != nil && !s.types[reflect.TypeOf(event)] {
+		return false
+	}
+
+	if s.filter != nil && !s.filter(event) {
+		return false
+	}
+
+	return true
+}
+
+// deliver places event on the subscriber's channel without blocking,
+// applying the configured OverflowPolicy when the buffer is full. It
+// returns false if the subscriber has been closed and should be removed.
+func (s *subscription) deliver(event interface{}) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	select {
+	case s.ch <- event:
+		return true
+	default:
+	}
+
+	switch s.overflow {
+	case OverflowDropNewest:
+		atomic.AddUint64(&s.dropped, 1)
+		return true
+	case OverflowDisconnect:
+		atomic.AddUint64(&s.dropped, 1)
+		close(s.ch)
+		s.closed = true
+		return false
+	default: // OverflowDropOldest
+		select {
+		case <-s.ch:
+		default:
+		}
+
+		select {
+		case s.ch <- event:
+		default:
+		}
+
+		atomic.AddUint64(&s.dropped, 1)
+		return true
+	}
+}
+
+// sendEvent fans event out to every matching subscriber. It never blocks on
+// a single slow consumer: delivery is best-effort per the subscriber's own
+// OverflowPolicy.
 func (z *ZStack) sendEvent(event interface{}) {
-	z.events <- event
+	z.subMutex.RLock()
+	subs := make([]*subscription, len(z.subs))
+	copy(subs, z.subs)
+	z.subMutex.RUnlock()
+
+	var stale []*subscription
+
+	for _, s := range subs {
+		if !s.matches(event) {
+			continue
+		}
+
+		if !s.deliver(event) {
+			stale = append(stale, s)
+		}
+	}
+
+	if len(stale) > 0 {
+		z.removeSubscriptions(stale)
+	}
+}
+
+// Subscribe registers a new subscriber on the event bus. The returned cancel
+// function removes the subscription and should always be called to release
+// its buffer, even once ctx is done. Calling cancel also stops the internal
+// goroutine that watches ctx, so a long-lived or background ctx doesn't
+// leak it for the life of the process.
+func (z *ZStack) Subscribe(ctx context.Context, opts SubscribeOptions) (<-chan interface{}, func()) {
+	sub := newSubscription(opts)
+
+	z.subMutex.Lock()
+	z.subs = append(z.subs, sub)
+	z.subMutex.Unlock()
+
+	done := make(chan struct{})
+	var cancelOnce sync.Once
+
+	cancel := func() {
+		cancelOnce.Do(func() { close(done) })
+		z.removeSubscriptions([]*subscription{sub})
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			z.removeSubscriptions([]*subscription{sub})
+		case <-done:
+		}
+	}()
+
+	return sub.ch, cancel
+}
+
+func (z *ZStack) removeSubscriptions(remove []*subscription) {
+	z.subMutex.Lock()
+	defer z.subMutex.Unlock()
+
+	for _, r := range remove {
+		for i, s := range z.subs {
+			if s == r {
+				z.subs = append(z.subs[:i], z.subs[i+1:]...)
+				break
+			}
+		}
+	}
 }
 
+// SubscriberStats returns delivery health for every active subscriber,
+// including events dropped due to a full buffer.
+func (z *ZStack) SubscriberStats() []SubscriberStats {
+	z.subMutex.RLock()
+	defer z.subMutex.RUnlock()
+
+	stats := make([]SubscriberStats, len(z.subs))
+
+	for i, s := range z.subs {
+		stats[i] = SubscriberStats{
+			BufferSize: cap(s.ch),
+			Dropped:    atomic.LoadUint64(&s.dropped),
+		}
+	}
+
+	return stats
+}
+
+// ReadEvent is a thin wrapper over a default catch-all subscription, kept
+// for backward compatibility with callers expecting a single blocking read.
+// The default subscription is created on the first call, so events sent
+// before ReadEvent is first called are not delivered to it.
 func (z *ZStack) ReadEvent(ctx context.Context) (interface{}, error) {
+	z.defaultSubOnce.Do(func() {
+		z.defaultSub, _ = z.Subscribe(context.Background(), SubscribeOptions{BufferSize: 50, Overflow: OverflowDropOldest})
+	})
+
 	select {
-	case event := <-z.events:
+	case event := <-z.defaultSub:
 		return event, nil
 	case <-ctx.Done():
 		return nil, errors.New("context expired")
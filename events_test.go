@@ -0,0 +1,234 @@
+package zstack
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type eventA struct{ N int }
+type eventB struct{ N int }
+
+func TestSubscribe(t *testing.T) {
+	t.Run("delivers only events matching the type filter", func(t *testing.T) {
+		z := &ZStack{}
+
+		ch, cancel := z.Subscribe(context.Background(), SubscribeOptions{
+			Types:      []interface{}{eventA{}},
+			BufferSize: 2,
+		})
+		defer cancel()
+
+		z.sendEvent(eventB{N: 1})
+		z.sendEvent(eventA{N: 2})
+
+		select {
+		case evt := <-ch:
+			assert.Equal(t, eventA{N: 2}, evt)
+		case <-time.After(time.Second):
+			t.Fatal("expected the type-filtered event")
+		}
+
+		select {
+		case evt := <-ch:
+			t.Fatalf("unexpected additional event: %#v", evt)
+		case <-time.After(20 * time.Millisecond):
+		}
+	})
+
+	t.Run("applies an additional predicate filter", func(t *testing.T) {
+		z := &ZStack{}
+
+		ch, cancel := z.Subscribe(context.Background(), SubscribeOptions{
+			Filter: func(e interface{}) bool {
+				a, ok := e.(eventA)
+				return ok && a.N > 1
+			},
+			BufferSize: 2,
+		})
+		defer cancel()
+
+		z.sendEvent(eventA{N: 1})
+		z.sendEvent(eventA{N: 2})
+
+		select {
+		case evt := <-ch:
+			assert.Equal(t, eventA{N: 2}, evt)
+		case <-time.After(time.Second):
+			t.Fatal("expected the predicate-filtered event")
+		}
+	})
+
+	t.Run("drop-oldest overflow keeps the most recent event and counts the drop", func(t *testing.T) {
+		z := &ZStack{}
+
+		ch, cancel := z.Subscribe(context.Background(), SubscribeOptions{
+			BufferSize: 1,
+			Overflow:   OverflowDropOldest,
+		})
+		defer cancel()
+
+		z.sendEvent(eventA{N: 1})
+		z.sendEvent(eventA{N: 2})
+
+		assert.Equal(t, eventA{N: 2}, <-ch)
+
+		stats := z.SubscriberStats()
+		assert.Len(t, stats, 1)
+		assert.Equal(t, uint64(1), stats[0].Dropped)
+	})
+
+	t.Run("drop-newest overflow keeps the oldest buffered event and counts the drop", func(t *testing.T) {
+		z := &ZStack{}
+
+		ch, cancel := z.Subscribe(context.Background(), SubscribeOptions{
+			BufferSize: 1,
+			Overflow:   OverflowDropNewest,
+		})
+		defer cancel()
+
+		z.sendEvent(eventA{N: 1})
+		z.sendEvent(eventA{N: 2})
+
+		assert.Equal(t, eventA{N: 1}, <-ch)
+
+		stats := z.SubscriberStats()
+		assert.Len(t, stats, 1)
+		assert.Equal(t, uint64(1), stats[0].Dropped)
+	})
+
+	t.Run("disconnect overflow closes the channel and removes the subscriber", func(t *testing.T) {
+		z := &ZStack{}
+
+		ch, cancel := z.Subscribe(context.Background(), SubscribeOptions{
+			BufferSize: 1,
+			Overflow:   OverflowDisconnect,
+		})
+		defer cancel()
+
+		z.sendEvent(eventA{N: 1})
+		z.sendEvent(eventA{N: 2})
+
+		assert.Equal(t, eventA{N: 1}, <-ch)
+
+		_, ok := <-ch
+		assert.False(t, ok, "channel should be closed after a disconnect overflow")
+
+		assert.Empty(t, z.SubscriberStats(), "a disconnected subscriber should be removed")
+	})
+
+	t.Run("a slow subscriber never blocks delivery to others", func(t *testing.T) {
+		z := &ZStack{}
+
+		slow, cancelSlow := z.Subscribe(context.Background(), SubscribeOptions{
+			BufferSize: 1,
+			Overflow:   OverflowDropOldest,
+		})
+		defer cancelSlow()
+
+		fast, cancelFast := z.Subscribe(context.Background(), SubscribeOptions{BufferSize: 10})
+		defer cancelFast()
+
+		for i := 0; i < 5; i++ {
+			z.sendEvent(eventA{N: i})
+		}
+
+		for i := 0; i < 5; i++ {
+			select {
+			case evt := <-fast:
+				assert.Equal(t, eventA{N: i}, evt)
+			case <-time.After(time.Second):
+				t.Fatal("fast subscriber should have received every event")
+			}
+		}
+
+		assert.Equal(t, eventA{N: 4}, <-slow)
+	})
+
+	t.Run("cancel removes the subscription", func(t *testing.T) {
+		z := &ZStack{}
+
+		_, cancel := z.Subscribe(context.Background(), SubscribeOptions{BufferSize: 1})
+		assert.Len(t, z.SubscriberStats(), 1)
+
+		cancel()
+
+		assert.Empty(t, z.SubscriberStats())
+	})
+
+	t.Run("context cancellation removes the subscription", func(t *testing.T) {
+		z := &ZStack{}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		_, unsubscribe := z.Subscribe(ctx, SubscribeOptions{BufferSize: 1})
+		defer unsubscribe()
+
+		assert.Len(t, z.SubscriberStats(), 1)
+
+		cancel()
+
+		assert.Eventually(t, func() bool {
+			return len(z.SubscriberStats()) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("cancel stops the ctx-watcher goroutine even for a non-cancelling context", func(t *testing.T) {
+		z := &ZStack{}
+
+		runtime.GC()
+		baseline := runtime.NumGoroutine()
+
+		for i := 0; i < 50; i++ {
+			_, cancel := z.Subscribe(context.Background(), SubscribeOptions{BufferSize: 1})
+			cancel()
+		}
+
+		assert.Eventually(t, func() bool {
+			runtime.GC()
+			return runtime.NumGoroutine() <= baseline+5
+		}, time.Second, 10*time.Millisecond, "cancel should stop the watcher goroutine instead of leaking it for context.Background()")
+	})
+}
+
+func TestReadEvent(t *testing.T) {
+	t.Run("receives events sent on the bus", func(t *testing.T) {
+		z := &ZStack{}
+
+		result := make(chan interface{}, 1)
+
+		go func() {
+			evt, err := z.ReadEvent(context.Background())
+			assert.NoError(t, err)
+			result <- evt
+		}()
+
+		// The default subscription only exists once ReadEvent has been
+		// called, so wait for it before sending.
+		assert.Eventually(t, func() bool {
+			return len(z.SubscriberStats()) == 1
+		}, time.Second, time.Millisecond)
+
+		z.sendEvent(eventA{N: 1})
+
+		select {
+		case evt := <-result:
+			assert.Equal(t, eventA{N: 1}, evt)
+		case <-time.After(time.Second):
+			t.Fatal("ReadEvent never returned the event sent on the bus")
+		}
+	})
+
+	t.Run("returns an error when ctx expires first", func(t *testing.T) {
+		z := &ZStack{}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := z.ReadEvent(ctx)
+		assert.Error(t, err)
+	})
+}
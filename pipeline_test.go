@@ -0,0 +1,243 @@
+package zstack
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline(t *testing.T) {
+	t.Run("serialises acquisitions for the same request type", func(t *testing.T) {
+		p := newPipeline(PipelineOptions{MaxInflight: 4})
+
+		release, err := p.acquire(context.Background(), struct{ A int }{})
+		assert.NoError(t, err)
+
+		acquired := make(chan bool, 1)
+
+		go func() {
+			r, err := p.acquire(context.Background(), struct{ A int }{})
+			assert.NoError(t, err)
+			acquired <- true
+			r()
+		}()
+
+		select {
+		case <-acquired:
+			t.Fatal("second acquire for the same request type should have blocked")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		release()
+
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			t.Fatal("second acquire never unblocked after release")
+		}
+	})
+
+	t.Run("different request types proceed concurrently", func(t *testing.T) {
+		p := newPipeline(PipelineOptions{MaxInflight: 4})
+
+		releaseA, err := p.acquire(context.Background(), struct{ A int }{})
+		assert.NoError(t, err)
+		defer releaseA()
+
+		acquired := make(chan bool, 1)
+
+		go func() {
+			r, err := p.acquire(context.Background(), struct{ B int }{})
+			assert.NoError(t, err)
+			acquired <- true
+			r()
+		}()
+
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			t.Fatal("acquire for a different request type should not have blocked")
+		}
+	})
+
+	t.Run("two distinct request types for the same subsystem serialise against each other", func(t *testing.T) {
+		p := newPipeline(PipelineOptions{MaxInflight: 4})
+
+		release, err := p.acquire(context.Background(), zdoRequestA{})
+		assert.NoError(t, err)
+
+		acquired := make(chan bool, 1)
+
+		go func() {
+			r, err := p.acquire(context.Background(), zdoRequestB{})
+			assert.NoError(t, err)
+			acquired <- true
+			r()
+		}()
+
+		select {
+		case <-acquired:
+			t.Fatal("a different request type for the same subsystem should have blocked")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		release()
+
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			t.Fatal("second acquire never unblocked after release")
+		}
+	})
+
+	t.Run("WithSubsystem serialises a request type that can't implement Subsystemer itself", func(t *testing.T) {
+		p := newPipeline(PipelineOptions{MaxInflight: 4})
+
+		// An externally-defined request type (e.g. from the unpi/zcl
+		// libraries) has no way to implement Subsystemer directly, so it's
+		// tagged with WithSubsystem instead at the call site.
+		type externalRequest struct{ A int }
+
+		release, err := p.acquire(context.Background(), WithSubsystem(5, externalRequest{}))
+		assert.NoError(t, err)
+
+		acquired := make(chan bool, 1)
+
+		go func() {
+			r, err := p.acquire(context.Background(), zdoRequestA{})
+			assert.NoError(t, err)
+			acquired <- true
+			r()
+		}()
+
+		select {
+		case <-acquired:
+			t.Fatal("a zdoRequestA sharing subsystem 5 with the tagged request should have blocked")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		release()
+
+		select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			t.Fatal("second acquire never unblocked after release")
+		}
+	})
+
+	t.Run("bounds the number of concurrent inflight requests", func(t *testing.T) {
+		p := newPipeline(PipelineOptions{MaxInflight: 2})
+
+		var current, max int32
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+
+				// A distinct subsystem per request, so only MaxInflight (not
+				// per-subsystem serialisation) limits concurrency.
+				release, err := p.acquire(context.Background(), WithSubsystem(uint8(i), i))
+				assert.NoError(t, err)
+
+				n := atomic.AddInt32(&current, 1)
+				for {
+					m := atomic.LoadInt32(&max)
+					if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+						break
+					}
+				}
+
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&current, -1)
+				release()
+			}(i)
+		}
+
+		wg.Wait()
+
+		assert.LessOrEqual(t, int(max), 2)
+	})
+}
+
+func TestPipelinedResponder(t *testing.T) {
+	t.Run("unwraps a WithSubsystem-tagged request before forwarding to the broker", func(t *testing.T) {
+		var capturedReq interface{}
+
+		r := &pipelinedResponder{
+			next: requestRecorder(func(ctx context.Context, req interface{}, resp interface{}) error {
+				capturedReq = req
+				return nil
+			}),
+			p: newPipeline(PipelineOptions{MaxInflight: 4}),
+		}
+
+		original := struct{ A int }{A: 1}
+		err := r.MessageRequestResponse(context.Background(), WithSubsystem(5, original), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, original, capturedReq)
+	})
+}
+
+type requestRecorder func(ctx context.Context, req interface{}, resp interface{}) error
+
+func (f requestRecorder) MessageRequestResponse(ctx context.Context, req interface{}, resp interface{}) error {
+	return f(ctx, req, resp)
+}
+
+type zdoRequestA struct{}
+
+func (zdoRequestA) ZStackSubsystem() uint8 { return 5 }
+
+type zdoRequestB struct{}
+
+func (zdoRequestB) ZStackSubsystem() uint8 { return 5 }
+
+type countingWriter struct {
+	mutex sync.Mutex
+	calls int
+	bytes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.calls++
+	c.bytes += len(p)
+
+	return len(p), nil
+}
+
+func BenchmarkBatchWriter(b *testing.B) {
+	frame := make([]byte, 32)
+
+	b.Run("unbatched", func(b *testing.B) {
+		w := &countingWriter{}
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			_, _ = w.Write(frame)
+		}
+	})
+
+	b.Run("batched", func(b *testing.B) {
+		w := &countingWriter{}
+		bw := newBatchWriter(w, PipelineOptions{MaxBatchBytes: 4096, LingerDuration: 500 * time.Microsecond}, nil)
+		defer bw.Close()
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			_, _ = bw.Write(frame)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package zstack
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+// Transport dials the underlying byte stream to a Zigbee coordinator,
+// abstracting away whether that stream is a local UART, a TCP gateway, or
+// anything else io.ReadWriteCloser can represent. ZStack calls Dial again
+// whenever the previous connection fails, so implementations should be safe
+// to call repeatedly.
+type Transport interface {
+	Dial(ctx context.Context) (io.ReadWriteCloser, error)
+	String() string
+}
+
+// SerialTransport dials a local UART, such as a USB Zigbee dongle.
+type SerialTransport struct {
+	Device      string
+	Baud        int
+	Parity      serial.Parity
+	ReadTimeout time.Duration
+}
+
+func (s *SerialTransport) Dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	return serial.OpenPort(&serial.Config{
+		Name:        s.Device,
+		Baud:        s.Baud,
+		Parity:      s.Parity,
+		ReadTimeout: s.ReadTimeout,
+	})
+}
+
+func (s *SerialTransport) String() string {
+	return fmt.Sprintf("serial://%s", s.Device)
+}
+
+// TCPTransport dials a coordinator exposed over TCP, such as an SLZB-06,
+// ZBBridge or ser2net gateway. TLSConfig is optional; when nil the
+// connection is made in plaintext.
+type TCPTransport struct {
+	Address     string
+	DialTimeout time.Duration
+	KeepAlive   time.Duration
+	TLSConfig   *tls.Config
+}
+
+func (t *TCPTransport) Dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	dialer := &net.Dialer{
+		Timeout:   t.DialTimeout,
+		KeepAlive: t.KeepAlive,
+	}
+
+	if t.TLSConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", t.Address, t.TLSConfig)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", t.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (t *TCPTransport) String() string {
+	return fmt.Sprintf("tcp://%s", t.Address)
+}
+
+// staticTransport adapts the pre-opened io.ReadWriter accepted by New into a
+// Transport, so New and NewWithTransport can share connection handling. It
+// cannot be redialled, so a transport error on a statically provided stream
+// surfaces as a permanent failure rather than triggering reconnection.
+type staticTransport struct {
+	io.ReadWriter
+	dialed bool
+}
+
+func (s *staticTransport) Dial(ctx context.Context) (io.ReadWriteCloser, error) {
+	if s.dialed {
+		return nil, fmt.Errorf("zstack: static transport does not support reconnection")
+	}
+
+	s.dialed = true
+
+	return nopCloser{s.ReadWriter}, nil
+}
+
+func (s *staticTransport) String() string {
+	return "static"
+}
+
+type nopCloser struct {
+	io.ReadWriter
+}
+
+func (n nopCloser) Close() error {
+	return nil
+}
@@ -2,9 +2,9 @@ package zstack // import "github.com/shimmeringbee/zstack"
 
 import (
 	"context"
-	"github.com/shimmeringbee/unpi/broker"
 	"github.com/shimmeringbee/unpi/library"
 	"io"
+	"sync"
 	"time"
 )
 
@@ -14,18 +14,51 @@ type RequestResponder interface {
 
 type ZStack struct {
 	RequestResponder RequestResponder
+
+	library *library.Library
+	conn    connection
+
+	subMutex       sync.RWMutex
+	subs           []*subscription
+	defaultSub     <-chan interface{}
+	defaultSubOnce sync.Once
 }
 
 const DefaultZStackTimeout = 5 * time.Second
 const DefaultZStackRetries = 3
 
+// New constructs a ZStack that talks to the coordinator over an already
+// open io.ReadWriter, such as a UART opened by the caller. The stream cannot
+// be redialled, so a transport failure surfaces as a permanent error rather
+// than being retried; use NewWithTransport for automatic reconnection.
 func New(uart io.ReadWriter) *ZStack {
+	z, err := NewWithTransport(&staticTransport{ReadWriter: uart})
+	if err != nil {
+		// staticTransport wraps an already open stream, so its first Dial
+		// cannot fail; this would only trip if that invariant were broken.
+		panic(err)
+	}
+
+	return z
+}
+
+// NewWithTransport constructs a ZStack that owns its connection lifecycle
+// via the given Transport, reconnecting and re-establishing the broker
+// whenever a request fails with a transport error. It returns an error if
+// the initial dial fails, e.g. the coordinator is not yet reachable.
+func NewWithTransport(transport Transport) (*ZStack, error) {
 	ml := library.NewLibrary()
 	registerMessages(ml)
 
-	znp := broker.NewBroker(uart, uart, ml)
+	z := &ZStack{
+		library: ml,
+		conn:    connection{transport: transport},
+	}
+	z.RequestResponder = &reconnectingResponder{z: z}
 
-	return &ZStack{
-		RequestResponder: znp,
+	if err := z.dial(context.Background()); err != nil {
+		return nil, err
 	}
+
+	return z, nil
 }
\ No newline at end of file